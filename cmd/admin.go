@@ -0,0 +1,75 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/spf13/cobra"
+	"github.com/zededa/adam/pkg/x509"
+)
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Administer a running Adam server's device database",
+	Long:  `Administer a running Adam server's device database`,
+}
+
+var adminDeviceCmd = &cobra.Command{
+	Use:   "device",
+	Short: "Administer devices",
+	Long:  `Administer devices known to the Adam server`,
+}
+
+var adminDeviceEnrollCmd = &cobra.Command{
+	Use:   "enroll",
+	Short: "Enroll a device from a CSR",
+	Long:  `Sign a device-supplied PKCS#10 CertificateSignRequest and add the resulting certificate to the device database, without ever generating or storing the device's private key.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if csrFile == "" {
+			log.Fatalf("--csr must be set")
+		}
+		if deviceDatabasePath == "" {
+			log.Fatalf("device path must be set")
+		}
+		fi, err := os.Stat(deviceDatabasePath)
+		if err != nil {
+			log.Fatalf("device database path %s does not exist", deviceDatabasePath)
+		}
+		if !fi.IsDir() {
+			log.Fatalf("device database path %s is not a directory", deviceDatabasePath)
+		}
+		csrPEM, err := os.ReadFile(csrFile)
+		if err != nil {
+			log.Fatalf("unable to read CSR file %s: %v", csrFile, err)
+		}
+		csrCN, err := x509.CSRCommonName(csrPEM)
+		if err != nil {
+			log.Fatalf("invalid CSR: %v", err)
+		}
+		re := regexp.MustCompile(`[^a-zA-Z0-9\\.\\-]`)
+		cnSquashed := re.ReplaceAllString(csrCN, "_")
+		certPath := path.Join(deviceDatabasePath, fmt.Sprintf("%s.pem", cnSquashed))
+		if err := signCSRFile(csrFile, certPath, deviceDatabasePath, force); err != nil {
+			log.Fatalf("error enrolling device: %v", err)
+		}
+	},
+}
+
+func adminInit() {
+	adminCmd.AddCommand(adminDeviceCmd)
+
+	adminDeviceCmd.AddCommand(adminDeviceEnrollCmd)
+	adminDeviceEnrollCmd.Flags().StringVar(&deviceDatabasePath, "device-db", "", "path to directory where the device database is stored")
+	adminDeviceEnrollCmd.MarkFlagRequired("device-db")
+	adminDeviceEnrollCmd.Flags().StringVar(&csrFile, "csr", "", "path to a PEM-encoded PKCS#10 CertificateSignRequest to sign and enroll")
+	adminDeviceEnrollCmd.MarkFlagRequired("csr")
+	adminDeviceEnrollCmd.Flags().BoolVar(&force, "force", false, "replace an existing certificate with the same name")
+
+	adminMeasurementInit()
+}
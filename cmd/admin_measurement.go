@@ -0,0 +1,131 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zededa/adam/pkg/driver/common"
+)
+
+var (
+	globalOptionsPath     string
+	measurementPlatform   string
+	measurementLaunchMsmt string
+	measurementIDKeyDgst  string
+	measurementPolicy     uint64
+	measurementMRTD       string
+	measurementRTMRs      string
+	measurementIndex      int
+)
+
+var adminMeasurementCmd = &cobra.Command{
+	Use:   "measurement",
+	Short: "Administer trusted confidential-compute measurements",
+	Long:  `Administer the SEV-SNP/TDX trusted measurements used by the attestation endpoint`,
+}
+
+var adminMeasurementAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a trusted measurement",
+	Long:  `Add a trusted SEV-SNP or TDX measurement to the controller's global options`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if measurementPlatform != "snp" && measurementPlatform != "tdx" {
+			log.Fatalf("--platform must be one of: snp, tdx")
+		}
+		opts := loadGlobalOptions()
+		tm := &common.TrustedMeasurement{
+			Platform:          measurementPlatform,
+			LaunchMeasurement: measurementLaunchMsmt,
+			IDKeyDigest:       measurementIDKeyDgst,
+			Policy:            measurementPolicy,
+			MRTD:              measurementMRTD,
+		}
+		if measurementRTMRs != "" {
+			tm.RTMRs = strings.Split(measurementRTMRs, ",")
+		}
+		opts.TrustedMeasurements = append(opts.TrustedMeasurements, tm)
+		saveGlobalOptions(opts)
+	},
+}
+
+var adminMeasurementListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List trusted measurements",
+	Long:  `List the trusted SEV-SNP/TDX measurements in the controller's global options`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := loadGlobalOptions()
+		for i, tm := range opts.TrustedMeasurements {
+			fmt.Printf("%d: %+v\n", i, *tm)
+		}
+	},
+}
+
+var adminMeasurementRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove a trusted measurement",
+	Long:  `Remove a trusted measurement from the controller's global options by its index, as shown by 'measurement list'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := loadGlobalOptions()
+		if measurementIndex < 0 || measurementIndex >= len(opts.TrustedMeasurements) {
+			log.Fatalf("index %d out of range, have %d measurements", measurementIndex, len(opts.TrustedMeasurements))
+		}
+		opts.TrustedMeasurements = append(opts.TrustedMeasurements[:measurementIndex], opts.TrustedMeasurements[measurementIndex+1:]...)
+		saveGlobalOptions(opts)
+	},
+}
+
+// loadGlobalOptions reads the controller's global options from
+// globalOptionsPath, returning an empty GlobalOptions if the file does not
+// yet exist.
+func loadGlobalOptions() *common.GlobalOptions {
+	b, err := os.ReadFile(globalOptionsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &common.GlobalOptions{}
+		}
+		log.Fatalf("error reading global options %s: %v", globalOptionsPath, err)
+	}
+	var opts common.GlobalOptions
+	if err := json.Unmarshal(b, &opts); err != nil {
+		log.Fatalf("error parsing global options %s: %v", globalOptionsPath, err)
+	}
+	return &opts
+}
+
+func saveGlobalOptions(opts *common.GlobalOptions) {
+	b, err := json.MarshalIndent(opts, "", "  ")
+	if err != nil {
+		log.Fatalf("error encoding global options: %v", err)
+	}
+	if err := os.WriteFile(globalOptionsPath, b, 0644); err != nil {
+		log.Fatalf("error writing global options %s: %v", globalOptionsPath, err)
+	}
+}
+
+func adminMeasurementInit() {
+	adminCmd.AddCommand(adminMeasurementCmd)
+	adminMeasurementCmd.PersistentFlags().StringVar(&globalOptionsPath, "global-options", "", "path to the controller's global-options.json")
+	adminMeasurementCmd.MarkPersistentFlagRequired("global-options")
+
+	adminMeasurementCmd.AddCommand(adminMeasurementAddCmd)
+	adminMeasurementAddCmd.Flags().StringVar(&measurementPlatform, "platform", "", "platform of the measurement: snp or tdx")
+	adminMeasurementAddCmd.MarkFlagRequired("platform")
+	adminMeasurementAddCmd.Flags().StringVar(&measurementLaunchMsmt, "measurement", "", "hex-encoded SNP launch measurement")
+	adminMeasurementAddCmd.Flags().StringVar(&measurementIDKeyDgst, "id-key-digest", "", "hex-encoded SNP ID key digest")
+	adminMeasurementAddCmd.Flags().Uint64Var(&measurementPolicy, "policy", 0, "SNP policy bits")
+	adminMeasurementAddCmd.Flags().StringVar(&measurementMRTD, "mrtd", "", "hex-encoded TDX MRTD")
+	adminMeasurementAddCmd.Flags().StringVar(&measurementRTMRs, "rtmrs", "", "comma-separated hex-encoded TDX RTMR0-3")
+
+	adminMeasurementCmd.AddCommand(adminMeasurementListCmd)
+
+	adminMeasurementCmd.AddCommand(adminMeasurementRemoveCmd)
+	adminMeasurementRemoveCmd.Flags().IntVar(&measurementIndex, "index", -1, "index of the measurement to remove, as shown by 'measurement list'")
+	adminMeasurementRemoveCmd.MarkFlagRequired("index")
+}
@@ -12,9 +12,16 @@ import (
 )
 
 var (
-	cn string
+	cn         string
+	csrFile    string
+	keyTypeStr string
+	pkcs11URI  string
 )
 
+// signingCADir is the directory, under each of the onboarding and device
+// databases, where the CSR-signing CA key and certificate are persisted.
+const signingCADir = "signing-ca"
+
 var generateCmd = &cobra.Command{
 	Use:   "generate",
 	Short: "Generate certs for the Adam server and clients",
@@ -26,7 +33,16 @@ var generateServerCmd = &cobra.Command{
 	Short: "Generate server certs",
 	Long:  `Generate the necessary server certs`,
 	Run: func(cmd *cobra.Command, args []string) {
-		err := x509.Generate("", hosts, certPath, keyPath, force)
+		var err error
+		if pkcs11URI != "" {
+			err = x509.GenerateWithHSMKey("", hosts, certPath, pkcs11URI, force)
+		} else {
+			keyType, perr := x509.ParseKeyType(keyTypeStr)
+			if perr != nil {
+				log.Fatalf("%v", perr)
+			}
+			err = x509.Generate("", hosts, certPath, keyPath, force, keyType)
+		}
 		if err != nil {
 			log.Fatalf("error generating key/cert: %v", err)
 		}
@@ -49,10 +65,24 @@ var generateOnboardCmd = &cobra.Command{
 			log.Fatalf("onboarding database path %s is not a directory", onboardingDatabasePath)
 		}
 		re := regexp.MustCompile(`[^a-zA-Z0-9\\.\\-]`)
+		if csrFile != "" {
+			certPath, err := csrCertPath(csrFile, onboardingDatabasePath, re)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			if err := signCSRFile(csrFile, certPath, onboardingDatabasePath, force); err != nil {
+				log.Fatalf("error signing CSR: %v", err)
+			}
+			return
+		}
 		cnSquashed := re.ReplaceAllString(cn, "_")
 		certPath := path.Join(onboardingDatabasePath, fmt.Sprintf("%s.pem", cnSquashed))
 		keyPath := path.Join(onboardingDatabasePath, fmt.Sprintf("%s-key.pem", cnSquashed))
-		err = x509.Generate(cn, "", certPath, keyPath, force)
+		keyType, err := x509.ParseKeyType(keyTypeStr)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		err = x509.Generate(cn, "", certPath, keyPath, force, keyType)
 		if err != nil {
 			log.Fatalf("error generating key/cert: %v", err)
 		}
@@ -75,16 +105,67 @@ var generateDeviceCmd = &cobra.Command{
 			log.Fatalf("device database path %s is not a directory", deviceDatabasePath)
 		}
 		re := regexp.MustCompile(`[^a-zA-Z0-9\\.\\-]`)
+		if csrFile != "" {
+			certPath, err := csrCertPath(csrFile, deviceDatabasePath, re)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			if err := signCSRFile(csrFile, certPath, deviceDatabasePath, force); err != nil {
+				log.Fatalf("error signing CSR: %v", err)
+			}
+			return
+		}
 		cnSquashed := re.ReplaceAllString(cn, "_")
 		certPath := path.Join(deviceDatabasePath, fmt.Sprintf("%s.pem", cnSquashed))
 		keyPath := path.Join(deviceDatabasePath, fmt.Sprintf("%s-key.pem", cnSquashed))
-		err = x509.Generate(cn, "", certPath, keyPath, force)
+		keyType, err := x509.ParseKeyType(keyTypeStr)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		err = x509.Generate(cn, "", certPath, keyPath, force, keyType)
 		if err != nil {
 			log.Fatalf("error generating key/cert: %v", err)
 		}
 	},
 }
 
+// csrCertPath derives the path the certificate for the CSR at csrPath should
+// be filed under, from the CSR's own CommonName rather than the operator-
+// supplied --cn flag, so the filename can never disagree with the signed
+// certificate's subject.
+func csrCertPath(csrPath, databasePath string, sanitize *regexp.Regexp) (string, error) {
+	csrPEM, err := os.ReadFile(csrPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to read CSR file %s: %v", csrPath, err)
+	}
+	csrCN, err := x509.CSRCommonName(csrPEM)
+	if err != nil {
+		return "", fmt.Errorf("invalid CSR: %v", err)
+	}
+	cnSquashed := sanitize.ReplaceAllString(csrCN, "_")
+	return path.Join(databasePath, fmt.Sprintf("%s.pem", cnSquashed)), nil
+}
+
+// signCSRFile reads a PEM-encoded CSR from csrPath, signs it with the
+// database-local signing CA, and writes the resulting certificate to
+// certPath. No private key is generated or stored on the Adam side.
+func signCSRFile(csrPath, certPath, databasePath string, force bool) error {
+	if !force {
+		if _, err := os.Stat(certPath); err == nil {
+			return nil
+		}
+	}
+	csrPEM, err := os.ReadFile(csrPath)
+	if err != nil {
+		return fmt.Errorf("unable to read CSR file %s: %v", csrPath, err)
+	}
+	certPEM, err := x509.SignCSR(csrPEM, path.Join(databasePath, signingCADir))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, certPEM, 0644)
+}
+
 func generateInit() {
 	// generate server
 	generateCmd.AddCommand(generateServerCmd)
@@ -93,6 +174,8 @@ func generateInit() {
 	generateServerCmd.Flags().StringVar(&hosts, "hosts", "", "hostnames and/or IPs to use in the certificate, separated by ',', output to the certfile and keyfile; will not replace if they exist")
 	generateServerCmd.MarkFlagRequired("hosts")
 	generateServerCmd.Flags().BoolVar(&force, "force", false, "replace existing files")
+	generateServerCmd.Flags().StringVar(&keyTypeStr, "key-type", string(x509.DefaultKeyType), "type of key to generate: rsa2048, rsa4096, ecdsa-p256, ecdsa-p384, ed25519")
+	generateServerCmd.Flags().StringVar(&pkcs11URI, "pkcs11-uri", "", "PKCS#11 URI of an HSM-resident key to use for the server key, generated in the HSM on first use; when set, only the certificate is written to --certfile and --key-type/--keyfile are ignored")
 
 	// generate onboarding certs
 	generateCmd.AddCommand(generateOnboardCmd)
@@ -101,6 +184,8 @@ func generateInit() {
 	generateOnboardCmd.Flags().StringVar(&cn, "cn", "", "CN to use in the certificate; will not replace if one with the same CN exists")
 	generateOnboardCmd.MarkFlagRequired("cn")
 	generateOnboardCmd.Flags().BoolVar(&force, "force", false, "replace existing files")
+	generateOnboardCmd.Flags().StringVar(&csrFile, "csr", "", "path to a PEM-encoded PKCS#10 CertificateSignRequest to sign instead of generating a key/cert pair; the private key never leaves the caller")
+	generateOnboardCmd.Flags().StringVar(&keyTypeStr, "key-type", string(x509.DefaultKeyType), "type of key to generate: rsa2048, rsa4096, ecdsa-p256, ecdsa-p384, ed25519; ignored when --csr is set")
 
 	// generate device certs
 	generateCmd.AddCommand(generateDeviceCmd)
@@ -109,4 +194,6 @@ func generateInit() {
 	generateDeviceCmd.Flags().StringVar(&cn, "cn", "", "CN to use in the certificate; will not replace if one with the same CN exists")
 	generateDeviceCmd.MarkFlagRequired("cn")
 	generateDeviceCmd.Flags().BoolVar(&force, "force", false, "replace existing files")
+	generateDeviceCmd.Flags().StringVar(&csrFile, "csr", "", "path to a PEM-encoded PKCS#10 CertificateSignRequest to sign instead of generating a key/cert pair; the private key never leaves the caller")
+	generateDeviceCmd.Flags().StringVar(&keyTypeStr, "key-type", string(x509.DefaultKeyType), "type of key to generate: rsa2048, rsa4096, ecdsa-p256, ecdsa-p384, ed25519; ignored when --csr is set")
 }
@@ -0,0 +1,127 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package attest verifies the evidence a device submits to prove it booted
+// into a trusted state: a TPM 2.0 event log replayed against the PCR
+// templates stored in the controller database, or an AMD SEV-SNP/Intel TDX
+// confidential-compute report checked against trusted launch measurements.
+// VerifyAttestation is the single entry point that dispatches on whichever
+// evidence type a device sent.
+package attest
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+
+	eveattest "github.com/lf-edge/eve/api/go/attest"
+	"github.com/zededa/adam/pkg/driver/common"
+)
+
+// wildcard is the PCRValue.Value that matches any digest at that PCR index.
+const wildcard = "*"
+
+// VerifyAttestation verifies whichever evidence a device submitted - exactly
+// one of eventLog, snpReport, or tdxQuote should be non-empty - against the
+// controller's configured PCR templates and trusted measurements, and
+// reports whether the device attested successfully. It is the single entry
+// point the attestation endpoint handler should call to decide
+// DeviceOptions.Attested, regardless of which evidence type the device sent.
+func VerifyAttestation(eventLog []*eveattest.TpmEventLogEntry, snpReport, tdxQuote []byte, opts *common.GlobalOptions, roots RootCache) (bool, error) {
+	switch {
+	case len(snpReport) > 0:
+		_, err := VerifySNP(snpReport, roots, opts.TrustedMeasurements)
+		return err == nil, err
+	case len(tdxQuote) > 0:
+		_, err := VerifyTDX(tdxQuote, roots, opts.TrustedMeasurements)
+		return err == nil, err
+	default:
+		_, err := Verify(eventLog, opts.PCRTemplates, opts.EnforceTemplateAttestation)
+		return err == nil, err
+	}
+}
+
+// Verify replays eventLog against each of templates and returns the first
+// template whose PCR values all match the replayed digests. If enforce is
+// true, any event whose PCRIndex is not covered by a candidate template
+// causes that template to be rejected, rather than silently ignored.
+func Verify(eventLog []*eveattest.TpmEventLogEntry, templates []*common.PCRTemplate, enforce bool) (*common.PCRTemplate, error) {
+	byIndex := groupByIndex(eventLog)
+	for _, tmpl := range templates {
+		if matches(byIndex, tmpl, enforce) {
+			return tmpl, nil
+		}
+	}
+	return nil, fmt.Errorf("event log does not match any of %d PCR templates", len(templates))
+}
+
+// groupByIndex buckets event log entries by the PCR they extend.
+func groupByIndex(eventLog []*eveattest.TpmEventLogEntry) map[uint32][]*eveattest.TpmEventLogEntry {
+	byIndex := make(map[uint32][]*eveattest.TpmEventLogEntry)
+	for _, e := range eventLog {
+		byIndex[e.Index] = append(byIndex[e.Index], e)
+	}
+	return byIndex
+}
+
+// matches reports whether replaying byIndex produces the digests required by tmpl.
+func matches(byIndex map[uint32][]*eveattest.TpmEventLogEntry, tmpl *common.PCRTemplate, enforce bool) bool {
+	covered := make(map[uint32]bool, len(tmpl.PCRValues))
+	for _, pv := range tmpl.PCRValues {
+		covered[pv.Index] = true
+	}
+	if enforce {
+		for idx := range byIndex {
+			if !covered[idx] {
+				return false
+			}
+		}
+	}
+	for _, pv := range tmpl.PCRValues {
+		if pv.Value == wildcard {
+			continue
+		}
+		newHash, ok := hashForDigest(pv.Value)
+		if !ok {
+			return false
+		}
+		digest, err := replay(byIndex[pv.Index], newHash)
+		if err != nil || digest != pv.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// replay starts the PCR at all-zeros and extends it once per event in events,
+// computing PCR_new = H(PCR_old || H(event_data)) with the given hash bank.
+func replay(events []*eveattest.TpmEventLogEntry, newHash func() hash.Hash) (string, error) {
+	size := newHash().Size()
+	pcr := make([]byte, size)
+	for _, e := range events {
+		h := newHash()
+		h.Write(e.Event)
+		eventDigest := h.Sum(nil)
+
+		h = newHash()
+		h.Write(pcr)
+		h.Write(eventDigest)
+		pcr = h.Sum(nil)
+	}
+	return hex.EncodeToString(pcr), nil
+}
+
+// hashForDigest picks the hash bank whose digest size matches the hex-encoded
+// digest, since PCRValue does not carry the bank explicitly.
+func hashForDigest(digest string) (func() hash.Hash, bool) {
+	switch len(digest) {
+	case sha1.Size * 2:
+		return sha1.New, true
+	case sha256.Size * 2:
+		return sha256.New, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package attest
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	eveattest "github.com/lf-edge/eve/api/go/attest"
+	"github.com/zededa/adam/pkg/driver/common"
+)
+
+// extendSha256 replays a single sha256 PCR extend for use in test expectations.
+func extendSha256(pcr []byte, event []byte) []byte {
+	d := sha256.Sum256(event)
+	h := sha256.New()
+	h.Write(pcr)
+	h.Write(d[:])
+	return h.Sum(nil)
+}
+
+// extendSha1 replays a single sha1 PCR extend for use in test expectations.
+func extendSha1(pcr []byte, event []byte) []byte {
+	d := sha1.Sum(event)
+	h := sha1.New()
+	h.Write(pcr)
+	h.Write(d[:])
+	return h.Sum(nil)
+}
+
+func TestVerify(t *testing.T) {
+	pcr0 := make([]byte, sha256.Size)
+	pcr0 = extendSha256(pcr0, []byte("firmware-init"))
+	pcr0 = extendSha256(pcr0, []byte("bootloader"))
+
+	pcr1 := make([]byte, sha1.Size)
+	pcr1 = extendSha1(pcr1, []byte("kernel-cmdline"))
+
+	eventLog := []*eveattest.TpmEventLogEntry{
+		{Index: 0, Event: []byte("firmware-init")},
+		{Index: 0, Event: []byte("bootloader")},
+		{Index: 1, Event: []byte("kernel-cmdline")},
+	}
+
+	matching := &common.PCRTemplate{
+		EveVersion:      "1.0",
+		FirmwareVersion: "1.0",
+		PCRValues: []*common.PCRValue{
+			{Index: 0, Value: hex.EncodeToString(pcr0)},
+			{Index: 1, Value: hex.EncodeToString(pcr1)},
+		},
+	}
+
+	wildcardTemplate := &common.PCRTemplate{
+		EveVersion:      "1.0",
+		FirmwareVersion: "1.0",
+		PCRValues: []*common.PCRValue{
+			{Index: 0, Value: wildcard},
+			{Index: 1, Value: hex.EncodeToString(pcr1)},
+		},
+	}
+
+	mismatching := &common.PCRTemplate{
+		EveVersion:      "1.0",
+		FirmwareVersion: "1.0",
+		PCRValues: []*common.PCRValue{
+			{Index: 0, Value: hex.EncodeToString(make([]byte, sha256.Size))},
+			{Index: 1, Value: hex.EncodeToString(pcr1)},
+		},
+	}
+
+	uncoveredIndexOnly := &common.PCRTemplate{
+		EveVersion:      "1.0",
+		FirmwareVersion: "1.0",
+		PCRValues: []*common.PCRValue{
+			{Index: 0, Value: hex.EncodeToString(pcr0)},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		templates []*common.PCRTemplate
+		enforce   bool
+		wantMatch *common.PCRTemplate
+		wantErr   bool
+	}{
+		{
+			name:      "matches template with exact digests",
+			templates: []*common.PCRTemplate{mismatching, matching},
+			enforce:   true,
+			wantMatch: matching,
+		},
+		{
+			name:      "matches template with wildcard PCR",
+			templates: []*common.PCRTemplate{wildcardTemplate},
+			enforce:   true,
+			wantMatch: wildcardTemplate,
+		},
+		{
+			name:      "no templates match",
+			templates: []*common.PCRTemplate{mismatching},
+			enforce:   true,
+			wantErr:   true,
+		},
+		{
+			name:      "fails closed on uncovered PCR index when enforced",
+			templates: []*common.PCRTemplate{uncoveredIndexOnly},
+			enforce:   true,
+			wantErr:   true,
+		},
+		{
+			name:      "ignores uncovered PCR index when not enforced",
+			templates: []*common.PCRTemplate{uncoveredIndexOnly},
+			enforce:   false,
+			wantMatch: uncoveredIndexOnly,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(eventLog, tt.templates, tt.enforce)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Verify() expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() unexpected error: %v", err)
+			}
+			if got != tt.wantMatch {
+				t.Fatalf("Verify() = %v, want %v", got, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestVerifyAttestationDispatchesOnEvidenceType(t *testing.T) {
+	pcr0 := make([]byte, sha256.Size)
+	pcr0 = extendSha256(pcr0, []byte("firmware-init"))
+	eventLog := []*eveattest.TpmEventLogEntry{{Index: 0, Event: []byte("firmware-init")}}
+	opts := &common.GlobalOptions{
+		PCRTemplates: []*common.PCRTemplate{{
+			EveVersion: "1.0",
+			PCRValues:  []*common.PCRValue{{Index: 0, Value: hex.EncodeToString(pcr0)}},
+		}},
+	}
+
+	ok, err := VerifyAttestation(eventLog, nil, nil, opts, RootCache{})
+	if err != nil {
+		t.Fatalf("VerifyAttestation() with a matching TPM event log: unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatalf("VerifyAttestation() with a matching TPM event log: got false, want true")
+	}
+
+	ok, err = VerifyAttestation(nil, []byte("not a valid SNP report"), nil, opts, RootCache{})
+	if err == nil || ok {
+		t.Fatalf("VerifyAttestation() with a malformed SNP report: got (%v, %v), want (false, error)", ok, err)
+	}
+}
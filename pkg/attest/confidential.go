@@ -0,0 +1,231 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package attest
+
+import (
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/zededa/adam/pkg/driver/common"
+)
+
+// SNPReport is an AMD SEV-SNP ATTESTATION_REPORT, as laid out in the SEV-SNP
+// ABI specification: a fixed-size structure followed by an ECDSA P-384
+// signature over everything preceding it.
+type SNPReport struct {
+	Policy      uint64
+	Measurement []byte // 48 bytes
+	IDKeyDigest []byte // 48 bytes
+	ReportedTCB []byte // 8-byte TCB_VERSION, see ParseTCBVersion
+	ChipID      []byte // 64 bytes, identifies the reporting platform to AMD's KDS
+	Signature   []byte // 512-byte R||S signature region
+	Raw         []byte // the report as received, signature verified against this
+}
+
+// TDXQuote is an Intel TDX quote: a quote header, a TD report body carrying
+// MRTD and the four RTMRs, and a signature plus PCK certificate chain.
+type TDXQuote struct {
+	MRTD      []byte   // 48 bytes
+	RTMRs     [][]byte // RTMR0-3, 48 bytes each
+	Signature []byte
+	// PCKChain is the certification data's PCK certificate chain,
+	// leaf-first, as embedded in the quote: the PCK leaf certificate
+	// followed by zero or more intermediates and ending in a copy of
+	// Intel's root CA certificate.
+	PCKChain []*x509.Certificate
+	Raw      []byte
+}
+
+// SEV-SNP ATTESTATION_REPORT field offsets and sizes, per the SEV-SNP ABI spec.
+const (
+	snpReportMinSize   = 0x4A0
+	snpPolicyOffset    = 0x08
+	snpMeasurementOff  = 0x90
+	snpMeasurementSize = 48
+	snpIDKeyDigestOff  = 0xE0
+	snpIDKeyDigestSize = 48
+	snpReportedTCBOff  = 0x180
+	snpReportedTCBSize = 8
+	snpChipIDOff       = 0x1A0
+	snpChipIDSize      = 64
+	snpSignatureOff    = 0x2A0
+	snpSignatureSize   = 512
+)
+
+// ParseSNPReport parses a raw AMD SEV-SNP attestation report.
+func ParseSNPReport(report []byte) (*SNPReport, error) {
+	if len(report) < snpReportMinSize {
+		return nil, fmt.Errorf("SEV-SNP report too short: got %d bytes, want at least %d", len(report), snpReportMinSize)
+	}
+	policy := uint64(0)
+	for i := 0; i < 8; i++ {
+		policy |= uint64(report[snpPolicyOffset+i]) << (8 * i)
+	}
+	return &SNPReport{
+		Policy:      policy,
+		Measurement: report[snpMeasurementOff : snpMeasurementOff+snpMeasurementSize],
+		IDKeyDigest: report[snpIDKeyDigestOff : snpIDKeyDigestOff+snpIDKeyDigestSize],
+		ReportedTCB: report[snpReportedTCBOff : snpReportedTCBOff+snpReportedTCBSize],
+		ChipID:      report[snpChipIDOff : snpChipIDOff+snpChipIDSize],
+		Signature:   report[snpSignatureOff : snpSignatureOff+snpSignatureSize],
+		Raw:         report,
+	}, nil
+}
+
+// TD quote body field offsets (relative to the end of the 48-byte quote
+// header) and sizes, per the Intel TDX DCAP quote format.
+const (
+	tdQuoteHeaderSize = 48
+	tdBodyMinSize     = 552
+	tdMRTDOffset      = 120
+	tdMRTDSize        = 48
+	tdRTMR0Offset     = 312
+	tdRTMRSize        = 48
+	tdRTMRCount       = 4
+
+	// Quote signature data (the "auth data"), immediately following the
+	// TD report body: an ECDSA P-256 signature, the attestation public
+	// key it was signed with, and certification data binding that key to
+	// a PCK certificate chain.
+	tdSignatureOff         = 0
+	tdSignatureSize        = 64
+	tdAttestKeyOff         = tdSignatureOff + tdSignatureSize
+	tdAttestKeySize        = 64
+	tdCertTypeOff          = tdAttestKeyOff + tdAttestKeySize
+	tdCertLenOff           = tdCertTypeOff + 2
+	tdCertDataOff          = tdCertLenOff + 4
+	tdCertDataTypePCKChain = 5
+)
+
+// ParseTDXQuote parses a raw Intel TDX quote.
+func ParseTDXQuote(quote []byte) (*TDXQuote, error) {
+	if len(quote) < tdQuoteHeaderSize+tdBodyMinSize {
+		return nil, fmt.Errorf("TDX quote too short: got %d bytes, want at least %d", len(quote), tdQuoteHeaderSize+tdBodyMinSize)
+	}
+	body := quote[tdQuoteHeaderSize:]
+	rtmrs := make([][]byte, 0, tdRTMRCount)
+	for i := 0; i < tdRTMRCount; i++ {
+		start := tdRTMR0Offset + i*tdRTMRSize
+		rtmrs = append(rtmrs, body[start:start+tdRTMRSize])
+	}
+
+	authData := body[tdBodyMinSize:]
+	if len(authData) < tdCertDataOff {
+		return nil, fmt.Errorf("TDX quote signature data too short: got %d bytes, want at least %d", len(authData), tdCertDataOff)
+	}
+	certType := binary.LittleEndian.Uint16(authData[tdCertTypeOff : tdCertTypeOff+2])
+	if certType != tdCertDataTypePCKChain {
+		return nil, fmt.Errorf("TDX quote: unsupported certification data type %d, want PCK certificate chain (%d)", certType, tdCertDataTypePCKChain)
+	}
+	certLen := binary.LittleEndian.Uint32(authData[tdCertLenOff : tdCertLenOff+4])
+	if uint32(len(authData)-tdCertDataOff) < certLen {
+		return nil, fmt.Errorf("TDX quote certification data truncated: got %d bytes, want %d", len(authData)-tdCertDataOff, certLen)
+	}
+	chain, err := parsePEMCertChain(authData[tdCertDataOff : tdCertDataOff+int(certLen)])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PCK certificate chain: %v", err)
+	}
+
+	return &TDXQuote{
+		MRTD:      body[tdMRTDOffset : tdMRTDOffset+tdMRTDSize],
+		RTMRs:     rtmrs,
+		Signature: authData[tdSignatureOff : tdSignatureOff+tdSignatureSize],
+		PCKChain:  chain,
+		Raw:       quote,
+	}, nil
+}
+
+// VerifySNP verifies report's signature chain up to AMD's VCEK (fetched,
+// chained to the ARK root, and cached via roots) and checks its measurement,
+// ID key digest and policy against trusted. It returns the first matching
+// TrustedMeasurement.
+func VerifySNP(report []byte, roots RootCache, trusted []*common.TrustedMeasurement) (*common.TrustedMeasurement, error) {
+	parsed, err := ParseSNPReport(report)
+	if err != nil {
+		return nil, err
+	}
+	tcb, err := ParseTCBVersion(parsed.ReportedTCB)
+	if err != nil {
+		return nil, fmt.Errorf("SEV-SNP: %v", err)
+	}
+	vcek, err := roots.VCEK(parsed.ChipID, tcb)
+	if err != nil {
+		return nil, fmt.Errorf("SEV-SNP: failed to obtain VCEK: %v", err)
+	}
+	if err := vcek.VerifySNPSignature(parsed); err != nil {
+		return nil, fmt.Errorf("SEV-SNP: signature verification failed: %v", err)
+	}
+	for _, tm := range trusted {
+		if tm.Platform != "snp" {
+			continue
+		}
+		if tm.Policy != parsed.Policy {
+			continue
+		}
+		if !hexEqual(tm.LaunchMeasurement, parsed.Measurement) {
+			continue
+		}
+		if !hexEqual(tm.IDKeyDigest, parsed.IDKeyDigest) {
+			continue
+		}
+		return tm, nil
+	}
+	return nil, fmt.Errorf("SEV-SNP report does not match any of %d trusted measurements", len(trusted))
+}
+
+// VerifyTDX verifies quote's PCK certificate chain up to Intel's pinned PCS
+// root (fetched and cached via roots), verifies its signature against the
+// chain-validated PCK leaf key, and checks MRTD/RTMRs against trusted. It
+// returns the first matching TrustedMeasurement.
+func VerifyTDX(quote []byte, roots RootCache, trusted []*common.TrustedMeasurement) (*common.TrustedMeasurement, error) {
+	parsed, err := ParseTDXQuote(quote)
+	if err != nil {
+		return nil, err
+	}
+	root, err := roots.PCSRoot()
+	if err != nil {
+		return nil, fmt.Errorf("TDX: failed to obtain Intel PCS root: %v", err)
+	}
+	if err := VerifyTDXSignature(parsed, root); err != nil {
+		return nil, fmt.Errorf("TDX: signature verification failed: %v", err)
+	}
+	for _, tm := range trusted {
+		if tm.Platform != "tdx" {
+			continue
+		}
+		if !hexEqual(tm.MRTD, parsed.MRTD) {
+			continue
+		}
+		if len(tm.RTMRs) != len(parsed.RTMRs) {
+			continue
+		}
+		match := true
+		for i, rtmr := range tm.RTMRs {
+			if !hexEqual(rtmr, parsed.RTMRs[i]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return tm, nil
+		}
+	}
+	return nil, fmt.Errorf("TDX quote does not match any of %d trusted measurements", len(trusted))
+}
+
+// hexEqual reports whether hexDigest, hex-decoded, equals raw.
+func hexEqual(hexDigest string, raw []byte) bool {
+	decoded, err := hex.DecodeString(hexDigest)
+	if err != nil || len(decoded) != len(raw) {
+		return false
+	}
+	for i := range raw {
+		if decoded[i] != raw[i] {
+			return false
+		}
+	}
+	return true
+}
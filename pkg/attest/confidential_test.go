@@ -0,0 +1,381 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package attest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zededa/adam/pkg/driver/common"
+)
+
+func TestParseSNPReport(t *testing.T) {
+	report := make([]byte, snpReportMinSize)
+	report[snpPolicyOffset] = 0x01
+	for i := 0; i < snpMeasurementSize; i++ {
+		report[snpMeasurementOff+i] = byte(i)
+	}
+	for i := 0; i < snpIDKeyDigestSize; i++ {
+		report[snpIDKeyDigestOff+i] = byte(i + 1)
+	}
+	for i := 0; i < snpReportedTCBSize; i++ {
+		report[snpReportedTCBOff+i] = byte(i + 2)
+	}
+	for i := 0; i < snpChipIDSize; i++ {
+		report[snpChipIDOff+i] = byte(i + 3)
+	}
+
+	parsed, err := ParseSNPReport(report)
+	if err != nil {
+		t.Fatalf("ParseSNPReport() unexpected error: %v", err)
+	}
+	if parsed.Policy != 1 {
+		t.Errorf("Policy = %d, want 1", parsed.Policy)
+	}
+	if len(parsed.Measurement) != snpMeasurementSize || parsed.Measurement[1] != 1 {
+		t.Errorf("Measurement not parsed from the expected offset")
+	}
+	if len(parsed.IDKeyDigest) != snpIDKeyDigestSize || parsed.IDKeyDigest[0] != 1 {
+		t.Errorf("IDKeyDigest not parsed from the expected offset")
+	}
+	if len(parsed.ReportedTCB) != snpReportedTCBSize || parsed.ReportedTCB[0] != 2 {
+		t.Errorf("ReportedTCB not parsed from the expected offset")
+	}
+	if len(parsed.ChipID) != snpChipIDSize || parsed.ChipID[0] != 3 {
+		t.Errorf("ChipID not parsed from the expected offset")
+	}
+
+	if _, err := ParseSNPReport(report[:snpReportMinSize-1]); err == nil {
+		t.Errorf("ParseSNPReport() on a truncated report: expected an error, got none")
+	}
+}
+
+func TestParseTCBVersion(t *testing.T) {
+	tcb, err := ParseTCBVersion([]byte{0x01, 0x02, 0, 0, 0, 0, 0x03, 0x04})
+	if err != nil {
+		t.Fatalf("ParseTCBVersion() unexpected error: %v", err)
+	}
+	want := TCBVersion{BootLoader: 1, TEE: 2, SNP: 3, Microcode: 4}
+	if tcb != want {
+		t.Errorf("ParseTCBVersion() = %+v, want %+v", tcb, want)
+	}
+
+	if _, err := ParseTCBVersion([]byte{0x01}); err == nil {
+		t.Errorf("ParseTCBVersion() on a short buffer: expected an error, got none")
+	}
+}
+
+// buildTDXQuote assembles a TDX quote byte stream with a fixed MRTD (byte i
+// set to i) and RTMRs (every byte of RTMRn set to n+1), followed by
+// certification data holding chainPEM. The signature is left zero-filled so
+// callers can overwrite it after signing.
+func buildTDXQuote(t *testing.T, chainPEM []byte) []byte {
+	t.Helper()
+	quote := make([]byte, tdQuoteHeaderSize+tdBodyMinSize+tdCertDataOff+len(chainPEM))
+	body := quote[tdQuoteHeaderSize:]
+	for i := 0; i < tdMRTDSize; i++ {
+		body[tdMRTDOffset+i] = byte(i)
+	}
+	for r := 0; r < tdRTMRCount; r++ {
+		for i := 0; i < tdRTMRSize; i++ {
+			body[tdRTMR0Offset+r*tdRTMRSize+i] = byte(r + 1)
+		}
+	}
+	authData := body[tdBodyMinSize:]
+	binary.LittleEndian.PutUint16(authData[tdCertTypeOff:], tdCertDataTypePCKChain)
+	binary.LittleEndian.PutUint32(authData[tdCertLenOff:], uint32(len(chainPEM)))
+	copy(authData[tdCertDataOff:], chainPEM)
+	return quote
+}
+
+func TestParseTDXQuote(t *testing.T) {
+	_, _, chainPEM := newTDXChain(t)
+	quote := buildTDXQuote(t, chainPEM)
+
+	parsed, err := ParseTDXQuote(quote)
+	if err != nil {
+		t.Fatalf("ParseTDXQuote() unexpected error: %v", err)
+	}
+	if len(parsed.MRTD) != tdMRTDSize || parsed.MRTD[1] != 1 {
+		t.Errorf("MRTD not parsed from the expected offset")
+	}
+	if len(parsed.RTMRs) != tdRTMRCount {
+		t.Fatalf("got %d RTMRs, want %d", len(parsed.RTMRs), tdRTMRCount)
+	}
+	for r, rtmr := range parsed.RTMRs {
+		if rtmr[0] != byte(r+1) {
+			t.Errorf("RTMR%d not parsed from the expected offset", r)
+		}
+	}
+	if len(parsed.PCKChain) != 2 {
+		t.Errorf("got %d PCK chain certs, want 2", len(parsed.PCKChain))
+	}
+
+	if _, err := ParseTDXQuote(quote[:tdQuoteHeaderSize+tdBodyMinSize-1]); err == nil {
+		t.Errorf("ParseTDXQuote() on a truncated quote: expected an error, got none")
+	}
+	if _, err := ParseTDXQuote(quote[:tdQuoteHeaderSize+tdBodyMinSize+tdCertDataOff-1]); err == nil {
+		t.Errorf("ParseTDXQuote() on truncated signature data: expected an error, got none")
+	}
+}
+
+// newSNPChain generates a self-signed ARK root, an ASK intermediate signed
+// by the ARK, and a VCEK leaf signed by the ASK, all ECDSA P-384 as real
+// AMD certs are, returning the ASK+ARK PEM bundle (as served by AMD's KDS
+// cert_chain endpoint) and the VCEK leaf's DER and private key.
+func newSNPChain(t *testing.T) (chainPEM []byte, vcekDER []byte, vcekKey *ecdsa.PrivateKey) {
+	t.Helper()
+	arkKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ARK key: %v", err)
+	}
+	arkTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ARK"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	arkDER, err := x509.CreateCertificate(rand.Reader, arkTemplate, arkTemplate, &arkKey.PublicKey, arkKey)
+	if err != nil {
+		t.Fatalf("failed to create ARK certificate: %v", err)
+	}
+	ark, err := x509.ParseCertificate(arkDER)
+	if err != nil {
+		t.Fatalf("failed to parse ARK certificate: %v", err)
+	}
+
+	askKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ASK key: %v", err)
+	}
+	askTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "ASK"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	askDER, err := x509.CreateCertificate(rand.Reader, askTemplate, ark, &askKey.PublicKey, arkKey)
+	if err != nil {
+		t.Fatalf("failed to create ASK certificate: %v", err)
+	}
+	ask, err := x509.ParseCertificate(askDER)
+	if err != nil {
+		t.Fatalf("failed to parse ASK certificate: %v", err)
+	}
+
+	vcekKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate VCEK key: %v", err)
+	}
+	vcekTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "VCEK"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	vcekDER, err = x509.CreateCertificate(rand.Reader, vcekTemplate, ask, &vcekKey.PublicKey, askKey)
+	if err != nil {
+		t.Fatalf("failed to create VCEK certificate: %v", err)
+	}
+
+	var buf []byte
+	buf = append(buf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: askDER})...)
+	buf = append(buf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: arkDER})...)
+	return buf, vcekDER, vcekKey
+}
+
+// newTDXChain generates a self-signed Intel root and a PCK leaf signed by
+// it, both ECDSA P-256, returning the root cert, the leaf's private key and
+// the leaf+root PEM chain as embedded in a quote's certification data.
+func newTDXChain(t *testing.T) (root *x509.Certificate, leafKey *ecdsa.PrivateKey, chainPEM []byte) {
+	t.Helper()
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Intel root key: %v", err)
+	}
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Intel SGX Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatalf("failed to create Intel root certificate: %v", err)
+	}
+	root, err = x509.ParseCertificate(rootDER)
+	if err != nil {
+		t.Fatalf("failed to parse Intel root certificate: %v", err)
+	}
+
+	leafKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate PCK leaf key: %v", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "PCK Certificate"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, root, &leafKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatalf("failed to create PCK leaf certificate: %v", err)
+	}
+
+	var buf []byte
+	buf = append(buf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})...)
+	buf = append(buf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER})...)
+	return root, leafKey, buf
+}
+
+// signSNPReport copies measurement into an otherwise zero-filled
+// minimum-size report (so Policy, IDKeyDigest, ChipID and ReportedTCB are
+// all zero), signs it with key, and returns the raw bytes.
+func signSNPReport(t *testing.T, key *ecdsa.PrivateKey, measurement []byte) []byte {
+	t.Helper()
+	report := make([]byte, snpReportMinSize)
+	copy(report[snpMeasurementOff:], measurement)
+	digest := sha512.Sum384(report[:snpSignatureOff])
+	r, s, err := ecdsaSignRS(key, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign SNP report: %v", err)
+	}
+	copy(report[snpSignatureOff:], r)
+	copy(report[snpSignatureOff+snpSignatureRSOff:], s)
+	return report
+}
+
+// ecdsaSignRS signs digest and returns R and S as fixed-size little-endian
+// byte slices, matching the SEV-SNP/TDX wire encoding.
+func ecdsaSignRS(key *ecdsa.PrivateKey, digest []byte) (r, s []byte, err error) {
+	sigR, sigS, err := ecdsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	size := (key.Params().BitSize + 7) / 8
+	return reverseBytes(leftPad(sigR.Bytes(), size)), reverseBytes(leftPad(sigS.Bytes(), size)), nil
+}
+
+func leftPad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
+
+func TestVerifySNP(t *testing.T) {
+	chainPEM, vcekDER, vcekKey := newSNPChain(t)
+	measurement := make([]byte, snpMeasurementSize)
+	for i := range measurement {
+		measurement[i] = byte(i)
+	}
+	report := signSNPReport(t, vcekKey, measurement)
+	parsed, err := ParseSNPReport(report)
+	if err != nil {
+		t.Fatalf("ParseSNPReport() unexpected error: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, amdChainCertFile), chainPEM, 0600); err != nil {
+		t.Fatalf("failed to seed ASK/ARK chain cache: %v", err)
+	}
+	tcb, err := ParseTCBVersion(parsed.ReportedTCB)
+	if err != nil {
+		t.Fatalf("ParseTCBVersion() unexpected error: %v", err)
+	}
+	vcekFile := filepath.Join(dir, fmt.Sprintf("amd-vcek-%s-%02x%02x%02x%02x.der",
+		hex.EncodeToString(parsed.ChipID), tcb.BootLoader, tcb.TEE, tcb.SNP, tcb.Microcode))
+	if err := os.WriteFile(vcekFile, vcekDER, 0600); err != nil {
+		t.Fatalf("failed to seed VCEK cache: %v", err)
+	}
+	roots := RootCache{Dir: dir}
+
+	trusted := []*common.TrustedMeasurement{{
+		Platform:          "snp",
+		LaunchMeasurement: hex.EncodeToString(measurement),
+		IDKeyDigest:       hex.EncodeToString(make([]byte, snpIDKeyDigestSize)),
+	}}
+	if _, err := VerifySNP(report, roots, trusted); err != nil {
+		t.Errorf("VerifySNP() unexpected error: %v", err)
+	}
+
+	report[0] ^= 0xFF // corrupt a byte inside the signed region
+	if _, err := VerifySNP(report, roots, trusted); err == nil {
+		t.Errorf("VerifySNP() on a tampered report: expected an error, got none")
+	}
+}
+
+func TestVerifyTDX(t *testing.T) {
+	root, leafKey, chainPEM := newTDXChain(t)
+	quote := buildTDXQuote(t, chainPEM)
+	digest := sha256.Sum256(quote[:tdQuoteHeaderSize+tdBodyMinSize])
+	r, s, err := ecdsaSignRS(leafKey, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign TDX quote: %v", err)
+	}
+	authData := quote[tdQuoteHeaderSize+tdBodyMinSize:]
+	copy(authData[tdSignatureOff:], r)
+	copy(authData[tdSignatureOff+32:], s)
+
+	dir := t.TempDir()
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	if err := os.WriteFile(filepath.Join(dir, pcsRootCertFile), rootPEM, 0600); err != nil {
+		t.Fatalf("failed to seed Intel PCS root cache: %v", err)
+	}
+	roots := RootCache{Dir: dir}
+
+	mrtd := make([]byte, tdMRTDSize)
+	for i := range mrtd {
+		mrtd[i] = byte(i)
+	}
+	rtmrs := make([]string, tdRTMRCount)
+	for i := range rtmrs {
+		rtmr := make([]byte, tdRTMRSize)
+		for j := range rtmr {
+			rtmr[j] = byte(i + 1)
+		}
+		rtmrs[i] = hex.EncodeToString(rtmr)
+	}
+	trusted := []*common.TrustedMeasurement{{
+		Platform: "tdx",
+		MRTD:     hex.EncodeToString(mrtd),
+		RTMRs:    rtmrs,
+	}}
+	if _, err := VerifyTDX(quote, roots, trusted); err != nil {
+		t.Errorf("VerifyTDX() unexpected error: %v", err)
+	}
+
+	quote[tdQuoteHeaderSize] ^= 0xFF // corrupt a byte inside the signed region
+	if _, err := VerifyTDX(quote, roots, trusted); err == nil {
+		t.Errorf("VerifyTDX() on a tampered quote: expected an error, got none")
+	}
+}
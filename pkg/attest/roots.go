@@ -0,0 +1,302 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package attest
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// rootFetchClient bounds how long a RootCache will wait on AMD's KDS or
+// Intel's PCS before giving up, so a stalled endpoint cannot hang
+// verification indefinitely.
+var rootFetchClient = &http.Client{Timeout: 10 * time.Second}
+
+const (
+	amdChainCertFile = "amd-ask-ark-chain.pem"
+	pcsRootCertFile  = "intel-pcs-root.pem"
+
+	// amdChainURL serves the ASK+ARK certificate chain for the Milan
+	// (SEV-SNP) product line from AMD's Key Distribution Service. The VCEK
+	// leaf certificate, which actually signs a given report, is chip- and
+	// TCB-specific and is fetched separately by VCEK.
+	amdChainURL = "https://kdsintf.amd.com/vcek/v1/Milan/cert_chain"
+	// amdVCEKURLPrefix is the base URL from which the chip- and
+	// TCB-specific VCEK leaf certificate is fetched, given a chip ID and
+	// the four TCB component patch levels.
+	amdVCEKURLPrefix = "https://kdsintf.amd.com/vcek/v1/Milan"
+	// intelPCSURL serves Intel's pinned SGX/TDX PCK root CA certificate,
+	// the trust anchor that every platform's PCK certificate chain is
+	// rooted in.
+	intelPCSURL = "https://api.trustedservices.intel.com/sgx/certification/v4/rootcacert"
+)
+
+// RootCache fetches the AMD VCEK chain and Intel PCS root certificates used
+// to verify confidential-compute attestation reports, and caches them under
+// Dir so repeat verifications do not hit the network.
+type RootCache struct {
+	Dir string
+}
+
+// VCEKVerifier verifies an SNPReport's signature against a VCEK leaf cert
+// that has already been chain-validated up to AMD's ARK root.
+type VCEKVerifier struct {
+	cert *x509.Certificate
+}
+
+// TCBVersion is an AMD SEV-SNP TCB_VERSION: the four platform component
+// patch levels that, together with the reporting chip's ID, identify the
+// exact VCEK certificate a report was signed with.
+type TCBVersion struct {
+	BootLoader uint8
+	TEE        uint8
+	SNP        uint8
+	Microcode  uint8
+}
+
+// ParseTCBVersion decodes an 8-byte little-endian TCB_VERSION field, as laid
+// out in the SEV-SNP ABI specification: boot loader SPL, TEE SPL, 4 reserved
+// bytes, SNP SPL, microcode SPL.
+func ParseTCBVersion(b []byte) (TCBVersion, error) {
+	if len(b) != 8 {
+		return TCBVersion{}, fmt.Errorf("TCB_VERSION must be 8 bytes, got %d", len(b))
+	}
+	return TCBVersion{
+		BootLoader: b[0],
+		TEE:        b[1],
+		SNP:        b[6],
+		Microcode:  b[7],
+	}, nil
+}
+
+// VCEK fetches (or loads from cache) the VCEK leaf certificate for the given
+// chip and TCB patch level, and validates it chains to AMD's ARK root via
+// the cached ASK intermediate.
+func (c RootCache) VCEK(chipID []byte, tcb TCBVersion) (*VCEKVerifier, error) {
+	ark, ask, err := c.askArkChain()
+	if err != nil {
+		return nil, err
+	}
+
+	chipHex := hex.EncodeToString(chipID)
+	file := fmt.Sprintf("amd-vcek-%s-%02x%02x%02x%02x.der", chipHex, tcb.BootLoader, tcb.TEE, tcb.SNP, tcb.Microcode)
+	url := fmt.Sprintf("%s/%s?blSPL=%d&teeSPL=%d&snpSPL=%d&ucodeSPL=%d",
+		amdVCEKURLPrefix, chipHex, tcb.BootLoader, tcb.TEE, tcb.SNP, tcb.Microcode)
+	der, err := c.loadOrFetchBytes(file, url)
+	if err != nil {
+		return nil, err
+	}
+	vcek, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse VCEK certificate: %v", err)
+	}
+
+	if err := ask.CheckSignatureFrom(ark); err != nil {
+		return nil, fmt.Errorf("ASK certificate does not chain to the ARK root: %v", err)
+	}
+	if err := vcek.CheckSignatureFrom(ask); err != nil {
+		return nil, fmt.Errorf("VCEK certificate does not chain to the ASK intermediate: %v", err)
+	}
+	return &VCEKVerifier{cert: vcek}, nil
+}
+
+// askArkChain returns the cached AMD ASK (intermediate) and ARK (root)
+// certificates, fetching and caching the bundle on first use.
+func (c RootCache) askArkChain() (ark, ask *x509.Certificate, err error) {
+	body, err := c.loadOrFetchBytes(amdChainCertFile, amdChainURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, err := parsePEMCertChain(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse AMD ASK/ARK chain: %v", err)
+	}
+	if len(chain) != 2 {
+		return nil, nil, fmt.Errorf("AMD ASK/ARK chain: got %d certificates, want 2 (ASK, ARK)", len(chain))
+	}
+	ask, ark = chain[0], chain[1]
+	if err := ark.CheckSignatureFrom(ark); err != nil {
+		return nil, nil, fmt.Errorf("ARK certificate is not self-signed: %v", err)
+	}
+	return ark, ask, nil
+}
+
+// PCSRoot returns the cached, pinned Intel SGX/TDX PCK root CA certificate,
+// fetching and caching it on first use. It is the trust anchor a TDX quote's
+// PCK certificate chain must be validated against; it never signs a quote
+// itself.
+func (c RootCache) PCSRoot() (*x509.Certificate, error) {
+	body, err := c.loadOrFetchBytes(pcsRootCertFile, intelPCSURL)
+	if err != nil {
+		return nil, err
+	}
+	return parsePEMCert(body)
+}
+
+// loadOrFetchBytes returns the cached contents of file under Dir, fetching
+// and caching url's response body on first use.
+func (c RootCache) loadOrFetchBytes(file, url string) ([]byte, error) {
+	path := filepath.Join(c.Dir, file)
+	if b, err := os.ReadFile(path); err == nil {
+		return b, nil
+	}
+
+	resp, err := rootFetchClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %v", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: unexpected status %s", url, resp.Status)
+	}
+
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create root cache directory %s: %v", c.Dir, err)
+	}
+	if err := os.WriteFile(path, body, 0600); err != nil {
+		return nil, fmt.Errorf("failed to cache %s: %v", path, err)
+	}
+	return body, nil
+}
+
+func parsePEMCert(b []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// parsePEMCertChain parses a concatenation of PEM-encoded certificates, in
+// the order they appear, leaf-first.
+func parsePEMCertChain(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := b
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in PEM data")
+	}
+	return certs, nil
+}
+
+// VerifySNPSignature verifies report's ECDSA P-384 signature, over the
+// report bytes preceding the signature field, against the VCEK key.
+func (v *VCEKVerifier) VerifySNPSignature(report *SNPReport) error {
+	pub, ok := v.cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("VCEK certificate does not hold an ECDSA public key")
+	}
+	r, s := splitSignature(report.Signature, snpSignatureRSOff, 48)
+	digest := sha512.Sum384(report.Raw[:snpSignatureOff])
+	if !ecdsa.Verify(pub, digest[:], r, s) {
+		return fmt.Errorf("signature does not verify against VCEK")
+	}
+	return nil
+}
+
+// VerifyTDXSignature validates quote's PCK certificate chain up to the
+// pinned Intel root, then verifies quote's ECDSA P-256 signature, over the
+// quote header and TD report body, against the chain-validated PCK leaf key.
+func VerifyTDXSignature(quote *TDXQuote, root *x509.Certificate) error {
+	leaf, err := verifyPCKChain(quote.PCKChain, root)
+	if err != nil {
+		return err
+	}
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("PCK leaf certificate does not hold an ECDSA public key")
+	}
+	r, s := splitSignature(quote.Signature, tdSignatureRSOff, 32)
+	digest := sha256.Sum256(quote.Raw[:tdQuoteHeaderSize+tdBodyMinSize])
+	if !ecdsa.Verify(pub, digest[:], r, s) {
+		return fmt.Errorf("signature does not verify against the PCK leaf certificate")
+	}
+	return nil
+}
+
+// verifyPCKChain validates that chain (leaf-first, as embedded in the
+// quote's certification data) terminates at the pinned Intel root, and
+// returns the leaf PCK certificate.
+func verifyPCKChain(chain []*x509.Certificate, root *x509.Certificate) (*x509.Certificate, error) {
+	if len(chain) < 2 {
+		return nil, fmt.Errorf("PCK certificate chain must include at least a leaf and a root, got %d certs", len(chain))
+	}
+	leaf := chain[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1 : len(chain)-1] {
+		intermediates.AddCert(c)
+	}
+	if last := chain[len(chain)-1]; !last.Equal(root) {
+		intermediates.AddCert(last)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		return nil, fmt.Errorf("PCK certificate chain does not validate to the pinned Intel root: %v", err)
+	}
+	return leaf, nil
+}
+
+// snpSignatureRSOff is the byte offset of the S component within an SNP
+// report's 512-byte signature region: AMD's ATTESTATION_REPORT.signature is
+// laid out as r[72]byte, s[72]byte, reserved[368]byte, so S starts at offset
+// 72, not halfway through the 512-byte region.
+const snpSignatureRSOff = 72
+
+// tdSignatureRSOff is the byte offset of the S component within a TDX
+// quote's 64-byte R||S signature, which packs two 32-byte components with
+// no reserved padding.
+const tdSignatureRSOff = 32
+
+// splitSignature decodes a raw little-endian R||S ECDSA signature, where R
+// starts at offset 0 and S starts at sOff, each occupying componentSize
+// bytes, into big.Int values.
+func splitSignature(sig []byte, sOff, componentSize int) (r, s *big.Int) {
+	r = new(big.Int).SetBytes(reverseBytes(sig[:componentSize]))
+	s = new(big.Int).SetBytes(reverseBytes(sig[sOff : sOff+componentSize]))
+	return r, s
+}
+
+func reverseBytes(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, v := range b {
+		out[len(b)-1-i] = v
+	}
+	return out
+}
@@ -107,10 +107,23 @@ type PCRTemplate struct {
 	PCRValues       []*PCRValue `json:"PCRValues"`
 }
 
+// TrustedMeasurement stores the expected confidential-compute launch
+// measurement for a device attesting via an AMD SEV-SNP or Intel TDX report
+// instead of a vTPM. Only the fields relevant to Platform are populated.
+type TrustedMeasurement struct {
+	Platform          string   `json:"platform"`                    // "snp" or "tdx"
+	LaunchMeasurement string   `json:"launchMeasurement,omitempty"` // SNP MEASUREMENT, hex-encoded
+	IDKeyDigest       string   `json:"idKeyDigest,omitempty"`       // SNP ID_KEY_DIGEST, hex-encoded
+	Policy            uint64   `json:"policy,omitempty"`            // SNP POLICY bits
+	MRTD              string   `json:"mrtd,omitempty"`              // TDX MRTD, hex-encoded
+	RTMRs             []string `json:"rtmrs,omitempty"`             // TDX RTMR0-3, hex-encoded
+}
+
 // GlobalOptions configure controller behaviour for attestation requests
 type GlobalOptions struct {
-	EnforceTemplateAttestation bool           `json:"enforceTemplateAttestation"`
-	PCRTemplates               []*PCRTemplate `json:"PCRTemplates"`
+	EnforceTemplateAttestation bool                  `json:"enforceTemplateAttestation"`
+	PCRTemplates               []*PCRTemplate        `json:"PCRTemplates"`
+	TrustedMeasurements        []*TrustedMeasurement `json:"trustedMeasurements,omitempty"`
 }
 
 // DeviceOptions stores received nonce, PCRTemplate structure received from device
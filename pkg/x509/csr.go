@@ -0,0 +1,179 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package x509
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	// SigningCACertFile is the file name of the CSR-signing CA certificate.
+	SigningCACertFile = "ca.pem"
+	// SigningCAKeyFile is the file name of the CSR-signing CA private key.
+	SigningCAKeyFile = "ca-key.pem"
+
+	signingCACommonName = "Adam CSR Signing CA"
+)
+
+// EnsureSigningCA loads the CSR-signing CA persisted in dir, generating and
+// persisting a new self-signed one on first use. It is used so that devices
+// enrolling via CSR get a cert chain rooted in a CA that is local to this
+// Adam instance, rather than a key Adam has to generate per device.
+func EnsureSigningCA(dir string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certPath := path.Join(dir, SigningCACertFile)
+	keyPath := path.Join(dir, SigningCAKeyFile)
+
+	if _, err := os.Stat(certPath); err == nil {
+		return loadSigningCA(certPath, keyPath)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create signing CA directory %s: %v", dir, err)
+	}
+
+	priv, err := rsa.GenerateKey(rand.Reader, rsaBits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate signing CA key: %v", err)
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName: signingCACommonName,
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(10 * validFor),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create signing CA certificate: %v", err)
+	}
+	if err := writePEM(certPath, "CERTIFICATE", der); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(keyPath, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(priv)); err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse newly created signing CA certificate: %v", err)
+	}
+	return cert, priv, nil
+}
+
+func loadSigningCA(certPath, keyPath string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read signing CA certificate %s: %v", certPath, err)
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("invalid signing CA certificate in %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse signing CA certificate %s: %v", certPath, err)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read signing CA key %s: %v", keyPath, err)
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("invalid signing CA key in %s", keyPath)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse signing CA key %s: %v", keyPath, err)
+	}
+
+	return cert, key, nil
+}
+
+// CSRCommonName parses a PEM-encoded PKCS#10 CertificateRequest and returns
+// its CommonName, without checking its signature. It lets a caller file the
+// certificate SignCSR will produce before the CSR has actually been signed.
+func CSRCommonName(csrPEM []byte) (string, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return "", errors.New("invalid CSR: expected a PEM-encoded CERTIFICATE REQUEST")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse CSR: %v", err)
+	}
+	if csr.Subject.CommonName == "" {
+		return "", errors.New("CSR must include a CommonName")
+	}
+	return csr.Subject.CommonName, nil
+}
+
+// SignCSR validates a PEM-encoded PKCS#10 CertificateRequest - checking its
+// signature and that it carries a CommonName - and returns a PEM-encoded
+// X.509 certificate signed by the CSR-signing CA persisted under caDir. The
+// device's private key never leaves the device: Adam only ever sees and
+// signs the public key embedded in the CSR.
+func SignCSR(csrPEM []byte, caDir string) ([]byte, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("invalid CSR: expected a PEM-encoded CERTIFICATE REQUEST")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSR: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("CSR signature verification failed: %v", err)
+	}
+	if csr.Subject.CommonName == "" {
+		return nil, errors.New("CSR must include a CommonName")
+	}
+
+	caCert, caKey, err := EnsureSigningCA(caDir)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      csr.Subject,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign CSR: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		return nil, fmt.Errorf("failed to encode signed certificate: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package x509
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+)
+
+func generateCSR(t *testing.T, cn string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CSR key: %v", err)
+	}
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: cn},
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestSignCSR(t *testing.T) {
+	caDir := filepath.Join(t.TempDir(), "ca")
+	csrPEM := generateCSR(t, "device-1234")
+
+	certPEM, err := SignCSR(csrPEM, caDir)
+	if err != nil {
+		t.Fatalf("SignCSR() unexpected error: %v", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("SignCSR() did not return a PEM-encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse signed certificate: %v", err)
+	}
+	if cert.Subject.CommonName != "device-1234" {
+		t.Errorf("signed certificate CommonName = %q, want %q", cert.Subject.CommonName, "device-1234")
+	}
+
+	caCert, _, err := EnsureSigningCA(caDir)
+	if err != nil {
+		t.Fatalf("EnsureSigningCA() unexpected error: %v", err)
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("signed certificate does not chain to the signing CA: %v", err)
+	}
+}
+
+func TestSignCSRInvalidSignature(t *testing.T) {
+	csrPEM := generateCSR(t, "device-1234")
+	block, _ := pem.Decode(csrPEM)
+	// Flip a byte inside the DER payload to invalidate the self-signature.
+	block.Bytes[len(block.Bytes)-1] ^= 0xFF
+	tampered := pem.EncodeToMemory(block)
+
+	if _, err := SignCSR(tampered, filepath.Join(t.TempDir(), "ca")); err == nil {
+		t.Error("SignCSR() with a tampered CSR signature: expected an error, got none")
+	}
+}
+
+func TestSignCSRMissingCommonName(t *testing.T) {
+	csrPEM := generateCSR(t, "")
+	if _, err := SignCSR(csrPEM, filepath.Join(t.TempDir(), "ca")); err == nil {
+		t.Error("SignCSR() with no CommonName: expected an error, got none")
+	}
+}
+
+func TestSignCSRInvalidPEM(t *testing.T) {
+	if _, err := SignCSR([]byte("not a CSR"), filepath.Join(t.TempDir(), "ca")); err == nil {
+		t.Error("SignCSR() with invalid PEM: expected an error, got none")
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package x509
+
+import "fmt"
+
+// KeyType identifies the key algorithm (and, for RSA, the key size) that
+// Generate should use for a new certificate's private key.
+type KeyType string
+
+const (
+	KeyTypeRSA2048   KeyType = "rsa2048"
+	KeyTypeRSA4096   KeyType = "rsa4096"
+	KeyTypeECDSAP256 KeyType = "ecdsa-p256"
+	KeyTypeECDSAP384 KeyType = "ecdsa-p384"
+	KeyTypeEd25519   KeyType = "ed25519"
+)
+
+// DefaultKeyType is used wherever a KeyType is not explicitly requested.
+const DefaultKeyType = KeyTypeRSA2048
+
+// ParseKeyType validates s against the supported KeyType values.
+func ParseKeyType(s string) (KeyType, error) {
+	switch kt := KeyType(s); kt {
+	case KeyTypeRSA2048, KeyTypeRSA4096, KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519:
+		return kt, nil
+	default:
+		return "", fmt.Errorf("unsupported key type %q, must be one of rsa2048, rsa4096, ecdsa-p256, ecdsa-p384, ed25519", s)
+	}
+}
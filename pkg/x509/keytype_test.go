@@ -0,0 +1,128 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package x509
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestParseKeyType(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    KeyType
+		wantErr bool
+	}{
+		{name: "rsa2048", in: "rsa2048", want: KeyTypeRSA2048},
+		{name: "rsa4096", in: "rsa4096", want: KeyTypeRSA4096},
+		{name: "ecdsa-p256", in: "ecdsa-p256", want: KeyTypeECDSAP256},
+		{name: "ecdsa-p384", in: "ecdsa-p384", want: KeyTypeECDSAP384},
+		{name: "ed25519", in: "ed25519", want: KeyTypeEd25519},
+		{name: "unsupported", in: "dsa", wantErr: true},
+		{name: "empty", in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKeyType(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseKeyType(%q) expected an error, got none", tt.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeyType(%q) unexpected error: %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseKeyType(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPrivateKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyType KeyType
+		check   func(t *testing.T, priv interface{})
+		wantErr bool
+	}{
+		{
+			name:    "rsa2048",
+			keyType: KeyTypeRSA2048,
+			check: func(t *testing.T, priv interface{}) {
+				key, ok := priv.(*rsa.PrivateKey)
+				if !ok {
+					t.Fatalf("newPrivateKey(rsa2048) returned %T, want *rsa.PrivateKey", priv)
+				}
+				if bits := key.N.BitLen(); bits != 2048 {
+					t.Errorf("RSA key size = %d bits, want 2048", bits)
+				}
+			},
+		},
+		{
+			name:    "rsa4096",
+			keyType: KeyTypeRSA4096,
+			check: func(t *testing.T, priv interface{}) {
+				key, ok := priv.(*rsa.PrivateKey)
+				if !ok {
+					t.Fatalf("newPrivateKey(rsa4096) returned %T, want *rsa.PrivateKey", priv)
+				}
+				if bits := key.N.BitLen(); bits != 4096 {
+					t.Errorf("RSA key size = %d bits, want 4096", bits)
+				}
+			},
+		},
+		{
+			name:    "ecdsa-p256",
+			keyType: KeyTypeECDSAP256,
+			check: func(t *testing.T, priv interface{}) {
+				if _, ok := priv.(*ecdsa.PrivateKey); !ok {
+					t.Fatalf("newPrivateKey(ecdsa-p256) returned %T, want *ecdsa.PrivateKey", priv)
+				}
+			},
+		},
+		{
+			name:    "ecdsa-p384",
+			keyType: KeyTypeECDSAP384,
+			check: func(t *testing.T, priv interface{}) {
+				if _, ok := priv.(*ecdsa.PrivateKey); !ok {
+					t.Fatalf("newPrivateKey(ecdsa-p384) returned %T, want *ecdsa.PrivateKey", priv)
+				}
+			},
+		},
+		{
+			name:    "ed25519",
+			keyType: KeyTypeEd25519,
+			check: func(t *testing.T, priv interface{}) {
+				if _, ok := priv.(ed25519.PrivateKey); !ok {
+					t.Fatalf("newPrivateKey(ed25519) returned %T, want ed25519.PrivateKey", priv)
+				}
+			},
+		},
+		{
+			name:    "unsupported",
+			keyType: KeyType("dsa"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priv, err := newPrivateKey(tt.keyType)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("newPrivateKey(%q) expected an error, got none", tt.keyType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newPrivateKey(%q) unexpected error: %v", tt.keyType, err)
+			}
+			tt.check(t, priv)
+		})
+	}
+}
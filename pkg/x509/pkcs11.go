@@ -0,0 +1,358 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package x509
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// hsmSigner adapts an RSA keypair resident in a PKCS#11 token to the
+// crypto.Signer interface, so the rest of Adam can sign with an HSM key
+// exactly as it would with one loaded from disk.
+type hsmSigner struct {
+	ctx        *pkcs11.Ctx
+	session    pkcs11.SessionHandle
+	privHandle pkcs11.ObjectHandle
+	pub        crypto.PublicKey
+}
+
+// Public returns the public half of the HSM-resident keypair.
+func (s *hsmSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs digest using the HSM-resident private key. opts must request
+// PKCS#1 v1.5 padding (the default for crypto/rsa), since that is the only
+// mechanism wired up below.
+func (s *hsmSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, fmt.Errorf("pkcs11: RSA-PSS signing is not supported, only PKCS#1 v1.5")
+	}
+	prefix, err := pkcs1v15HashPrefix(opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}
+	if err := s.ctx.SignInit(s.session, mechanism, s.privHandle); err != nil {
+		return nil, fmt.Errorf("pkcs11: SignInit failed: %v", err)
+	}
+	return s.ctx.Sign(s.session, append(prefix, digest...))
+}
+
+func pkcs1v15HashPrefix(h crypto.Hash) ([]byte, error) {
+	prefix, ok := rsaHashPrefixes[h]
+	if !ok {
+		return nil, fmt.Errorf("pkcs11: unsupported hash %v for PKCS#1 v1.5 signing", h)
+	}
+	return prefix, nil
+}
+
+// rsaHashPrefixes holds the DigestInfo ASN.1 prefixes prepended to the raw
+// digest before a PKCS#1 v1.5 RSA sign operation, as required by CKM_RSA_PKCS.
+var rsaHashPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// hsmParams is the subset of a "pkcs11:" URI (RFC 7512) that Adam needs to
+// locate a slot, token and PIN: module-path, token, pin-value, and object.
+type hsmParams struct {
+	modulePath string
+	tokenLabel string
+	pin        string
+	objectID   string
+}
+
+// parseHSMURI parses a simplified "pkcs11:module-path=...;token=...;pin-value=...;object=..."
+// URI into its component attributes.
+func parseHSMURI(uri string) (*hsmParams, error) {
+	if !strings.HasPrefix(uri, "pkcs11:") {
+		return nil, fmt.Errorf("pkcs11: URI must start with \"pkcs11:\": %q", uri)
+	}
+	params := &hsmParams{objectID: "adam"}
+	for _, attr := range strings.Split(strings.TrimPrefix(uri, "pkcs11:"), ";") {
+		if attr == "" {
+			continue
+		}
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("pkcs11: malformed URI attribute %q", attr)
+		}
+		value, err := url.QueryUnescape(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("pkcs11: malformed URI attribute %q: %v", attr, err)
+		}
+		switch kv[0] {
+		case "module-path":
+			params.modulePath = value
+		case "token":
+			params.tokenLabel = value
+		case "pin-value":
+			params.pin = value
+		case "object":
+			params.objectID = value
+		}
+	}
+	if params.modulePath == "" {
+		return nil, fmt.Errorf("pkcs11: URI %q is missing required attribute module-path", uri)
+	}
+	return params, nil
+}
+
+// newHSMSigner opens the PKCS#11 module named by uri, finds or generates an
+// RSA-2048 keypair labeled by the URI's object attribute, and returns a
+// crypto.Signer backed by the HSM-resident private key.
+func newHSMSigner(uri string) (*hsmSigner, error) {
+	params, err := parseHSMURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := pkcs11.New(params.modulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11: failed to load module %s", params.modulePath)
+	}
+	if err := ctx.Initialize(); err != nil {
+		return nil, fmt.Errorf("pkcs11: failed to initialize module %s: %v", params.modulePath, err)
+	}
+
+	slot, err := findSlot(ctx, params.tokenLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: failed to open session: %v", err)
+	}
+	if params.pin != "" {
+		if err := ctx.Login(session, pkcs11.CKU_USER, params.pin); err != nil {
+			return nil, fmt.Errorf("pkcs11: failed to login: %v", err)
+		}
+	}
+
+	pub, priv, err := findOrGenerateKeyPair(ctx, session, params.objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hsmSigner{ctx: ctx, session: session, privHandle: priv, pub: pub}, nil
+}
+
+func findSlot(ctx *pkcs11.Ctx, tokenLabel string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11: failed to list slots: %v", err)
+	}
+	if tokenLabel == "" {
+		if len(slots) == 0 {
+			return 0, fmt.Errorf("pkcs11: no slots with a token present")
+		}
+		return slots[0], nil
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if strings.TrimRight(info.Label, " ") == tokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("pkcs11: no slot found for token %q", tokenLabel)
+}
+
+// findOrGenerateKeyPair returns the RSA keypair labeled id in the token,
+// generating a new RSA-2048 keypair under that label if none exists yet.
+func findOrGenerateKeyPair(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, id string) (crypto.PublicKey, pkcs11.ObjectHandle, error) {
+	pubTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, id),
+	}
+	if err := ctx.FindObjectsInit(session, pubTemplate); err != nil {
+		return nil, 0, fmt.Errorf("pkcs11: FindObjectsInit failed: %v", err)
+	}
+	found, _, err := ctx.FindObjects(session, 1)
+	ctx.FindObjectsFinal(session)
+	if err != nil {
+		return nil, 0, fmt.Errorf("pkcs11: FindObjects failed: %v", err)
+	}
+	if len(found) == 0 {
+		return generateKeyPair(ctx, session, id)
+	}
+	pub, err := publicKeyFromHandle(ctx, session, found[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	privHandle, err := findPrivateKeyHandle(ctx, session, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pub, privHandle, nil
+}
+
+func findPrivateKeyHandle(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, id string) (pkcs11.ObjectHandle, error) {
+	privTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, id),
+	}
+	if err := ctx.FindObjectsInit(session, privTemplate); err != nil {
+		return 0, fmt.Errorf("pkcs11: FindObjectsInit failed: %v", err)
+	}
+	defer ctx.FindObjectsFinal(session)
+	found, _, err := ctx.FindObjects(session, 1)
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11: FindObjects failed: %v", err)
+	}
+	if len(found) == 0 {
+		return 0, fmt.Errorf("pkcs11: no private key found for label %q", id)
+	}
+	return found[0], nil
+}
+
+func generateKeyPair(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, id string) (crypto.PublicKey, pkcs11.ObjectHandle, error) {
+	pubTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, id),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{0x01, 0x00, 0x01}),
+	}
+	privTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, id),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	}
+	pubHandle, privHandle, err := ctx.GenerateKeyPair(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)},
+		pubTemplate, privTemplate)
+	if err != nil {
+		return nil, 0, fmt.Errorf("pkcs11: GenerateKeyPair failed: %v", err)
+	}
+	pub, err := publicKeyFromHandle(ctx, session, pubHandle)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pub, privHandle, nil
+}
+
+func publicKeyFromHandle(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, handle pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := ctx.GetAttributeValue(session, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: GetAttributeValue failed: %v", err)
+	}
+	modulus := new(big.Int).SetBytes(attrs[0].Value)
+	exponent := new(big.Int).SetBytes(attrs[1].Value)
+	return &rsa.PublicKey{N: modulus, E: int(exponent.Int64())}, nil
+}
+
+// GenerateWithHSMKey generates (or reuses) an RSA keypair inside the PKCS#11
+// token identified by uri and issues a self-signed certificate for cn/hosts
+// using that HSM-resident key, writing only the certificate to certPath. The
+// private key never leaves the HSM: at TLS serving time, the server must
+// load the keypair via HSMTLSCertificate(certPath, uri) rather than reading
+// a key file from disk.
+//
+// Nothing in this tree's TLS listener calls HSMTLSCertificate yet - that
+// wiring belongs in whatever sets up the server's tls.Config - so until it
+// is added, --pkcs11-uri only produces a certificate file, not an HSM-backed
+// running server.
+func GenerateWithHSMKey(cn, hosts, certPath, uri string, force bool) error {
+	if !force {
+		if _, err := os.Stat(certPath); err == nil {
+			return nil
+		}
+	}
+
+	signer, err := newHSMSigner(uri)
+	if err != nil {
+		return err
+	}
+
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName: cn,
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(validFor),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range strings.Split(hosts, ",") {
+		if h == "" {
+			continue
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, signer.Public(), signer)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %v", err)
+	}
+	return writePEM(certPath, "CERTIFICATE", der)
+}
+
+// HSMTLSCertificate loads the certificate written by GenerateWithHSMKey from
+// certPath and pairs it with a crypto.Signer backed by the PKCS#11-resident
+// private key named by uri, opening a fresh session with the token. The
+// result can be returned from a tls.Config's GetCertificate so the TLS
+// server signs the handshake with the HSM-resident key directly, without
+// ever reading it from disk. The signer supports PKCS#1 v1.5 only, so a
+// handshake that selects RSA-PSS, as every TLS 1.3 handshake with an RSA key
+// does, fails.
+func HSMTLSCertificate(certPath, uri string) (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate %s: %v", certPath, err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("invalid certificate in %s", certPath)
+	}
+
+	signer, err := newHSMSigner(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{block.Bytes},
+		PrivateKey:  signer,
+	}, nil
+}
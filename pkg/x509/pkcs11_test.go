@@ -0,0 +1,74 @@
+// Copyright (c) 2019 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package x509
+
+import "testing"
+
+func TestParseHSMURI(t *testing.T) {
+	tests := []struct {
+		name    string
+		uri     string
+		want    hsmParams
+		wantErr bool
+	}{
+		{
+			name: "module-path only",
+			uri:  "pkcs11:module-path=/usr/lib/softhsm/libsofthsm2.so",
+			want: hsmParams{modulePath: "/usr/lib/softhsm/libsofthsm2.so", objectID: "adam"},
+		},
+		{
+			name: "all attributes",
+			uri:  "pkcs11:module-path=/usr/lib/softhsm2.so;token=adam-token;pin-value=1234;object=server-key",
+			want: hsmParams{modulePath: "/usr/lib/softhsm2.so", tokenLabel: "adam-token", pin: "1234", objectID: "server-key"},
+		},
+		{
+			name: "percent-encoded pin",
+			uri:  "pkcs11:module-path=/usr/lib/softhsm2.so;pin-value=%31%32%33%34",
+			want: hsmParams{modulePath: "/usr/lib/softhsm2.so", pin: "1234", objectID: "adam"},
+		},
+		{
+			name:    "missing scheme",
+			uri:     "/usr/lib/softhsm2.so",
+			wantErr: true,
+		},
+		{
+			name:    "missing module-path",
+			uri:     "pkcs11:token=adam-token",
+			wantErr: true,
+		},
+		{
+			name:    "malformed attribute",
+			uri:     "pkcs11:module-path",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHSMURI(tt.uri)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseHSMURI(%q) expected an error, got none", tt.uri)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHSMURI(%q) unexpected error: %v", tt.uri, err)
+			}
+			if *got != tt.want {
+				t.Errorf("parseHSMURI(%q) = %+v, want %+v", tt.uri, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPKCS1v15HashPrefix(t *testing.T) {
+	for h := range rsaHashPrefixes {
+		if _, err := pkcs1v15HashPrefix(h); err != nil {
+			t.Errorf("pkcs1v15HashPrefix(%v) unexpected error: %v", h, err)
+		}
+	}
+	if _, err := pkcs1v15HashPrefix(0); err == nil {
+		t.Errorf("pkcs1v15HashPrefix(0) expected an error, got none")
+	}
+}